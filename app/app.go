@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http/fcgi"
+)
+
+// App runs a Router over a transport: raw TCP (Run) or FastCGI (RunFCGI),
+// mirroring how beego's App.Run picks between http.Serve and fcgi.Serve.
+type App struct {
+	router *Router
+}
+
+func NewApp(router *Router) *App {
+	return &App{router: router}
+}
+
+// Run serves the app over raw TCP on addr, handling each connection with
+// the keep-alive-aware request loop in handleConnection.
+func (a *App) Run(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			fmt.Println("Error accepting connection:", err)
+			continue
+		}
+		go handleConnection(conn, a.router)
+	}
+}
+
+// RunFCGI serves the app over FastCGI, for running behind a web server
+// such as nginx or Apache in shared hosting. network is "tcp" or "unix".
+func (a *App) RunFCGI(network, addr string) error {
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	return fcgi.Serve(l, a.router)
+}
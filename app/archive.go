@@ -0,0 +1,130 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// archiveGetHandler streams a tar or zip archive of the files named in the
+// "files" query parameter (comma-separated), selecting the format with
+// "format=tar|zip" (default zip), e.g.
+// GET /files/archive?files=a.txt,b.txt&format=tar
+func archiveGetHandler(ctx *Context) Response {
+	query, err := url.ParseQuery(ctx.rawQuery)
+	if err != nil {
+		return Response{statusCode: 400, reason: "Bad Request", contentType: "text/plain", body: "invalid query string"}
+	}
+
+	names := strings.Split(query.Get("files"), ",")
+	if len(names) == 0 || names[0] == "" {
+		return Response{statusCode: 400, reason: "Bad Request", contentType: "text/plain", body: "files query parameter is required"}
+	}
+	for _, name := range names {
+		if !isSafeFileName(name) {
+			return Response{statusCode: 400, reason: "Bad Request", contentType: "text/plain", body: "invalid file name: " + name}
+		}
+	}
+
+	format := query.Get("format")
+	if format == "" {
+		format = "zip"
+	}
+	if format != "zip" && format != "tar" {
+		return Response{statusCode: 400, reason: "Bad Request", contentType: "text/plain", body: "format must be tar or zip"}
+	}
+
+	pr, pw := io.Pipe()
+	dir := filesDir()
+	go func() {
+		var err error
+		if format == "tar" {
+			err = writeTarArchive(pw, dir, names)
+		} else {
+			err = writeZipArchive(pw, dir, names)
+		}
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		pw.Close()
+	}()
+
+	contentType := "application/zip"
+	if format == "tar" {
+		contentType = "application/x-tar"
+	}
+	return Response{statusCode: 200, reason: "OK", contentType: contentType, bodyReader: pr}
+}
+
+// isSafeFileName reports whether name is a plain file name within
+// filesDir(), rejecting path separators and "." segments that could escape
+// it (e.g. "../secret.txt").
+func isSafeFileName(name string) bool {
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return false
+	}
+	return name != "." && name != ".."
+}
+
+func writeTarArchive(w io.Writer, dir string, names []string) error {
+	tw := tar.NewWriter(w)
+	for _, name := range names {
+		if err := addFileToTar(tw, dir, name); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func addFileToTar(tw *tar.Writer, dir, name string) error {
+	f, err := os.Open(dir + name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+func writeZipArchive(w io.Writer, dir string, names []string) error {
+	zw := zip.NewWriter(w)
+	for _, name := range names {
+		if err := addFileToZip(zw, dir, name); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func addFileToZip(zw *zip.Writer, dir, name string) error {
+	f, err := os.Open(dir + name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	entry, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, f)
+	return err
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// EncoderFactory wraps a writer with a content-encoding codec, e.g. gzip or
+// brotli. Operators register new codecs with RegisterEncoding; built-ins
+// are registered in this file's init.
+type EncoderFactory func(io.Writer) io.WriteCloser
+
+var (
+	encodingFactories = map[string]EncoderFactory{}
+	encodingNames     []string // registration order, used to break q-value ties
+)
+
+// RegisterEncoding makes a codec available for content negotiation under
+// name (as used in Accept-Encoding / Content-Encoding).
+func RegisterEncoding(name string, factory EncoderFactory) {
+	if _, exists := encodingFactories[name]; !exists {
+		encodingNames = append(encodingNames, name)
+	}
+	encodingFactories[name] = factory
+}
+
+func init() {
+	RegisterEncoding("gzip", func(w io.Writer) io.WriteCloser {
+		return gzip.NewWriter(w)
+	})
+	RegisterEncoding("deflate", func(w io.Writer) io.WriteCloser {
+		// The HTTP "deflate" coding is zlib-wrapped DEFLATE (RFC 7230
+		// §4.2.2), not raw DEFLATE, so this needs compress/zlib rather
+		// than compress/flate.
+		return zlib.NewWriter(w)
+	})
+	RegisterEncoding("br", func(w io.Writer) io.WriteCloser {
+		return brotli.NewWriter(w)
+	})
+}
+
+type encodingPreference struct {
+	name string
+	q    float64
+}
+
+// parseAcceptEncoding parses an Accept-Encoding header into its codec
+// preferences, e.g. "gzip;q=0.5, br;q=1.0, *;q=0".
+func parseAcceptEncoding(header string) []encodingPreference {
+	var prefs []encodingPreference
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		fields := strings.SplitN(part, ";", 2)
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		if len(fields) == 2 {
+			if qv, ok := strings.CutPrefix(strings.TrimSpace(fields[1]), "q="); ok {
+				if parsed, err := strconv.ParseFloat(qv, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		prefs = append(prefs, encodingPreference{name, q})
+	}
+	return prefs
+}
+
+// negotiateEncoding picks the highest-ranked codec this server supports
+// (per the registry) for the given Accept-Encoding header, honoring
+// q-values and the "*" wildcard. name is "" when identity (no encoding) is
+// the right choice. ok is false when the client has ruled out every codec
+// we support, including identity (e.g. "identity;q=0, *;q=0"), meaning the
+// caller should reply 406 Not Acceptable.
+func negotiateEncoding(header string) (name string, ok bool) {
+	prefs := parseAcceptEncoding(header)
+	if len(prefs) == 0 {
+		return "", true
+	}
+
+	explicit := make(map[string]float64, len(prefs))
+	wildcardQ := -1.0
+	for _, p := range prefs {
+		if p.name == "*" {
+			wildcardQ = p.q
+			continue
+		}
+		explicit[p.name] = p.q
+	}
+
+	identityAcceptable := true
+	if q, stated := explicit["identity"]; stated {
+		identityAcceptable = q > 0
+	} else if wildcardQ == 0 {
+		identityAcceptable = false
+	}
+
+	best, bestQ := "", 0.0
+	for _, codec := range encodingNames {
+		q, stated := explicit[codec]
+		if !stated {
+			if wildcardQ < 0 {
+				continue
+			}
+			q = wildcardQ
+		}
+		if q > 0 && q > bestQ {
+			best, bestQ = codec, q
+		}
+	}
+
+	if best != "" {
+		return best, true
+	}
+	return "", identityAcceptable
+}
+
+var incompressibleContentTypePrefixes = []string{"image/", "video/", "application/zip"}
+
+func isIncompressibleContentType(contentType string) bool {
+	for _, prefix := range incompressibleContentTypePrefixes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// minCompressibleBodySize is the smallest in-memory body worth spending
+// CPU to compress; below it, the codec framing overhead can outweigh the
+// savings. Streamed bodies of unknown length (bodyReader without
+// contentLength) are always eligible, since their size isn't known upfront;
+// fixed-length streamed bodies are never encoded.
+const minCompressibleBodySize = 1024
+
+// Compression negotiates a response codec from Accept-Encoding (honoring
+// q-values, the "*" wildcard, and "identity;q=0"), replying 406 Not
+// Acceptable when nothing the client will accept is available. It skips
+// encoding small in-memory bodies and already-compressed content types,
+// and always sets Vary: Accept-Encoding so caches key on it correctly.
+func Compression() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *Context) Response {
+			codec := ""
+			if acceptEncoding, stated := ctx.headers["accept-encoding"]; stated {
+				var ok bool
+				codec, ok = negotiateEncoding(acceptEncoding)
+				if !ok {
+					return Response{
+						statusCode:  406,
+						reason:      "Not Acceptable",
+						contentType: "text/plain",
+						body:        "no acceptable content-encoding available",
+						headers:     httpHeaders{"Vary": "Accept-Encoding"},
+					}
+				}
+			}
+
+			res := next(ctx)
+			if res.headers == nil {
+				res.headers = httpHeaders{}
+			}
+			res.headers["Vary"] = "Accept-Encoding"
+
+			if codec == "" || isIncompressibleContentType(res.contentType) {
+				return res
+			}
+			if res.bodyReader == nil && len(res.body) < minCompressibleBodySize {
+				return res
+			}
+			if res.contentLength != nil {
+				// A fixed-length bodyReader (e.g. a single Range read) has
+				// already committed to an exact Content-Length; encoding it
+				// on the fly would change the length with no chance to
+				// recompute that header, so leave it as identity.
+				return res
+			}
+
+			res.headers["Content-Encoding"] = codec
+			if res.bodyReader == nil {
+				var b bytes.Buffer
+				w := encodingFactories[codec](&b)
+				w.Write([]byte(res.body))
+				w.Close()
+				res.body = b.String()
+			}
+			return res
+		}
+	}
+}
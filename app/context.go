@@ -0,0 +1,34 @@
+package main
+
+// Context wraps an incoming Request together with everything a handler or
+// middleware needs beyond the request itself: the path parameters matched
+// by the route, the client's remote address (e.g. for access logging), and
+// a per-request key/value store middleware can use to pass state down the
+// chain (such as the authenticated user from BasicAuth). It is transport
+// agnostic so the same handlers run whether the request arrived over raw
+// TCP or FastCGI.
+type Context struct {
+	*Request
+	remoteAddr string
+	params     map[string]string
+	store      map[string]interface{}
+}
+
+func newContext(remoteAddr string, req *Request, params map[string]string) *Context {
+	return &Context{Request: req, remoteAddr: remoteAddr, params: params, store: make(map[string]interface{})}
+}
+
+// Param returns the value bound to a named route segment, e.g. ":name" in
+// "/files/:name", or "" if there is no such parameter.
+func (c *Context) Param(name string) string {
+	return c.params[name]
+}
+
+func (c *Context) Set(key string, value interface{}) {
+	c.store[key] = value
+}
+
+func (c *Context) Get(key string) (interface{}, bool) {
+	v, ok := c.store[key]
+	return v, ok
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ServeHTTP makes Router satisfy http.Handler, so the same routes and
+// middleware can run behind net/http/fcgi (and therefore behind nginx or
+// Apache in shared hosting) instead of the raw TCP listener in serveConn.
+func (r *Router) ServeHTTP(w http.ResponseWriter, hr *http.Request) {
+	headers := make(map[string]string, len(hr.Header))
+	for name, values := range hr.Header {
+		if len(values) > 0 {
+			headers[strings.ToLower(name)] = values[0]
+		}
+	}
+
+	body, _ := io.ReadAll(hr.Body)
+	req := &Request{
+		method:      hr.Method,
+		path:        hr.URL.Path,
+		rawQuery:    hr.URL.RawQuery,
+		httpVersion: hr.Proto,
+		headers:     headers,
+		body:        string(body),
+	}
+
+	res := r.dispatch(hr.RemoteAddr, req)
+
+	for name, value := range res.headers {
+		w.Header().Set(name, value)
+	}
+	w.Header().Set("Content-Type", res.contentType)
+	if res.contentLength != nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(*res.contentLength, 10))
+	}
+	w.WriteHeader(res.statusCode)
+
+	if res.bodyReader == nil {
+		io.WriteString(w, res.body)
+		return
+	}
+
+	if closer, ok := res.bodyReader.(io.Closer); ok {
+		defer closer.Close()
+	}
+	if res.contentLength != nil {
+		io.Copy(w, res.bodyReader)
+		return
+	}
+	var dst io.Writer = w
+	var encoder io.WriteCloser
+	if factory, ok := encodingFactories[res.headers["Content-Encoding"]]; ok {
+		encoder = factory(w)
+		dst = encoder
+	}
+	io.Copy(dst, res.bodyReader)
+	if encoder != nil {
+		encoder.Close()
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func filesDir() string {
+	if len(os.Args) < 3 {
+		return ""
+	}
+	return os.Args[2]
+}
+
+// filesGetHandler streams the requested file straight from disk instead of
+// reading it fully into memory, so large files don't blow up server
+// memory; the file is closed once writeResponse has streamed it out. It
+// honors Range requests (single or multipart) with 206 Partial Content,
+// seeking with an *io.SectionReader instead of reading the whole file.
+func filesGetHandler(ctx *Context) Response {
+	f, err := os.Open(filesDir() + ctx.Param("name"))
+	if err != nil {
+		return Response{statusCode: 404, reason: "Not Found", contentType: "text/plain", body: err.Error()}
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return Response{statusCode: 500, reason: "Internal Server Error", contentType: "text/plain", body: err.Error()}
+	}
+	size := info.Size()
+
+	rangeHeader, hasRange := ctx.headers["range"]
+	if !hasRange {
+		return Response{
+			statusCode:  200,
+			reason:      "OK",
+			contentType: "application/octet-stream",
+			bodyReader:  f,
+			headers:     httpHeaders{"Accept-Ranges": "bytes"},
+		}
+	}
+
+	ranges, err := parseByteRanges(rangeHeader, size)
+	if err != nil {
+		f.Close()
+		return Response{
+			statusCode:  416,
+			reason:      "Range Not Satisfiable",
+			contentType: "text/plain",
+			body:        err.Error(),
+			headers:     httpHeaders{"Content-Range": fmt.Sprintf("bytes */%d", size)},
+		}
+	}
+
+	if len(ranges) == 1 {
+		return singleRangeResponse(f, ranges[0], size)
+	}
+	return multipartRangeResponse(f, ranges, size)
+}
+
+func filesPostHandler(ctx *Context) Response {
+	os.WriteFile(filesDir()+ctx.Param("name"), []byte(ctx.body), 0644)
+	return Response{statusCode: 201, reason: "Created", contentType: "text/plain", body: "saved"}
+}
+
+type byteRange struct {
+	start, end int64 // inclusive, both within [0, size)
+}
+
+func (b byteRange) length() int64 {
+	return b.end - b.start + 1
+}
+
+// parseByteRanges parses a "Range: bytes=..." header value into the byte
+// ranges it requests against a resource of the given size, supporting
+// "start-end", open-ended "start-" and suffix "-length" forms, and
+// multiple comma-separated ranges for multipart/byteranges responses.
+func parseByteRanges(header string, size int64) ([]byteRange, error) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(header, prefix) {
+		return nil, fmt.Errorf("unsupported range unit in %q", header)
+	}
+
+	specs := strings.Split(header[len(prefix):], ",")
+	ranges := make([]byteRange, 0, len(specs))
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		parts := strings.SplitN(spec, "-", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("malformed range: %q", spec)
+		}
+
+		var start, end int64
+		switch {
+		case parts[0] == "": // suffix range, e.g. "-500" means the last 500 bytes
+			suffixLength, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			if suffixLength > size {
+				suffixLength = size
+			}
+			start, end = size-suffixLength, size-1
+		case parts[1] == "": // open-ended range, e.g. "500-"
+			s, err := strconv.ParseInt(parts[0], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			start, end = s, size-1
+		default:
+			s, err := strconv.ParseInt(parts[0], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			e, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			start, end = s, e
+		}
+
+		if end >= size {
+			end = size - 1
+		}
+		if start < 0 || start > end || start >= size {
+			return nil, fmt.Errorf("range out of bounds: %q", spec)
+		}
+		ranges = append(ranges, byteRange{start, end})
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("no ranges given in %q", header)
+	}
+	return ranges, nil
+}
+
+// sectionReadCloser pairs an *io.SectionReader over an open file with that
+// file's Close, so a ranged read still closes the underlying descriptor
+// once writeResponse is done streaming it.
+type sectionReadCloser struct {
+	*io.SectionReader
+	f *os.File
+}
+
+func (s *sectionReadCloser) Close() error {
+	return s.f.Close()
+}
+
+func singleRangeResponse(f *os.File, rg byteRange, size int64) Response {
+	length := rg.length()
+	body := &sectionReadCloser{SectionReader: io.NewSectionReader(f, rg.start, length), f: f}
+	return Response{
+		statusCode:    206,
+		reason:        "Partial Content",
+		contentType:   "application/octet-stream",
+		bodyReader:    body,
+		contentLength: &length,
+		headers: httpHeaders{
+			"Accept-Ranges": "bytes",
+			"Content-Range": fmt.Sprintf("bytes %d-%d/%d", rg.start, rg.end, size),
+		},
+	}
+}
+
+// multipartRangeResponse streams a multipart/byteranges body, one part per
+// requested range, without buffering the whole thing: a goroutine feeds an
+// io.Pipe that the response reads from as it's written out.
+func multipartRangeResponse(f *os.File, ranges []byteRange, size int64) Response {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+
+	go func() {
+		defer f.Close()
+		for _, rg := range ranges {
+			header := textproto.MIMEHeader{}
+			header.Set("Content-Type", "application/octet-stream")
+			header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", rg.start, rg.end, size))
+
+			part, err := mw.CreatePart(header)
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if _, err := io.Copy(part, io.NewSectionReader(f, rg.start, rg.length())); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		if err := mw.Close(); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		pw.Close()
+	}()
+
+	return Response{
+		statusCode:  206,
+		reason:      "Partial Content",
+		contentType: "multipart/byteranges; boundary=" + mw.Boundary(),
+		bodyReader:  pr,
+		headers:     httpHeaders{"Accept-Ranges": "bytes"},
+	}
+}
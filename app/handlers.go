@@ -0,0 +1,17 @@
+package main
+
+func echoHandler(ctx *Context) Response {
+	return Response{statusCode: 200, reason: "OK", contentType: "text/plain", body: ctx.Param("msg")}
+}
+
+func userAgentHandler(ctx *Context) Response {
+	userAgent, exists := ctx.headers["user-agent"]
+	if !exists {
+		return Response{statusCode: 400, reason: "Not Found", contentType: "text/plain", body: "User-Agent header not found"}
+	}
+	return Response{statusCode: 200, reason: "OK", contentType: "text/plain", body: userAgent}
+}
+
+func mainPageHandler(_ *Context) Response {
+	return Response{statusCode: 200, reason: "OK", contentType: "text/html", body: "<h1>Hello World</h1>"}
+}
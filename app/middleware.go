@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Logger logs method, path, status code and latency for every request.
+func Logger() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *Context) Response {
+			start := time.Now()
+			res := next(ctx)
+			fmt.Printf("%s %s %s -> %d %s (%s)\n", ctx.remoteAddr, ctx.method, ctx.path, res.statusCode, res.reason, time.Since(start))
+			return res
+		}
+	}
+}
+
+// Recovery turns a panic anywhere downstream into a 500 response instead of
+// silently killing the connection's goroutine.
+func Recovery() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *Context) (res Response) {
+			defer func() {
+				if err := recover(); err != nil {
+					fmt.Println("recovered from panic:", err)
+					res = Response{statusCode: 500, reason: "Internal Server Error", contentType: "text/plain", body: "Internal Server Error"}
+				}
+			}()
+			return next(ctx)
+		}
+	}
+}
+
+// BasicAuth requires an `Authorization: Basic ...` header matching username
+// and password, replying 401 with a WWW-Authenticate challenge otherwise.
+func BasicAuth(username, password string) Middleware {
+	expected := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *Context) Response {
+			auth, exists := ctx.headers["authorization"]
+			if exists {
+				const prefix = "Basic "
+				if strings.HasPrefix(auth, prefix) && auth[len(prefix):] == expected {
+					return next(ctx)
+				}
+			}
+			return Response{
+				statusCode:  401,
+				reason:      "Unauthorized",
+				contentType: "text/plain",
+				body:        "Unauthorized",
+				headers:     httpHeaders{"WWW-Authenticate": `Basic realm="restricted"`},
+			}
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// HandlerFunc handles a single routed request.
+type HandlerFunc func(*Context) Response
+
+// Middleware wraps a HandlerFunc to run logic before and/or after it, in
+// the style of gin/beego. Middlewares are applied in the order they were
+// registered, outermost first.
+type Middleware func(HandlerFunc) HandlerFunc
+
+type route struct {
+	method     string
+	segments   []string
+	handler    HandlerFunc
+	middleware []Middleware
+}
+
+// Router maps method+path patterns (with optional ":name" segments) to
+// handlers, and runs every request through a chain of middleware.
+type Router struct {
+	routes     []route
+	middleware []Middleware
+}
+
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Use registers middleware that runs on every request, in the order added.
+func (r *Router) Use(mw ...Middleware) {
+	r.middleware = append(r.middleware, mw...)
+}
+
+// HandleFunc registers a handler for method and path. path segments
+// prefixed with ":" are wildcards bound into ctx.Param, e.g. "/files/:name".
+// mw, if given, runs only for this route, closer to the handler than the
+// global middleware registered with Use.
+func (r *Router) HandleFunc(method string, path string, handler HandlerFunc, mw ...Middleware) {
+	r.routes = append(r.routes, route{method, splitPath(path), handler, mw})
+}
+
+func splitPath(path string) []string {
+	trimmed := strings.Trim(path, "/")
+	if trimmed == "" {
+		return []string{}
+	}
+	return strings.Split(trimmed, "/")
+}
+
+// match finds the first registered route whose method and segment pattern
+// fit path, returning the bound path parameters.
+func (r *Router) match(method, path string) (*route, map[string]string, bool) {
+	requestSegments := splitPath(path)
+	for i := range r.routes {
+		rt := &r.routes[i]
+		if rt.method != method || len(rt.segments) != len(requestSegments) {
+			continue
+		}
+		params := make(map[string]string)
+		matched := true
+		for i, segment := range rt.segments {
+			if strings.HasPrefix(segment, ":") {
+				params[segment[1:]] = requestSegments[i]
+				continue
+			}
+			if segment != requestSegments[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return rt, params, true
+		}
+	}
+	return nil, nil, false
+}
+
+// dispatch runs a parsed request through the global middleware chain, then
+// any middleware registered for the matched route, down to the handler
+// itself, and returns its Response. It is transport agnostic; serveConn and
+// ServeHTTP adapt it to raw TCP and FastCGI/http.Handler respectively.
+func (r *Router) dispatch(remoteAddr string, req *Request) Response {
+	rt, params, ok := r.match(req.method, req.path)
+
+	var handler HandlerFunc
+	if !ok {
+		handler = notFoundHandler
+	} else {
+		handler = rt.handler
+		for i := len(rt.middleware) - 1; i >= 0; i-- {
+			handler = rt.middleware[i](handler)
+		}
+	}
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		handler = r.middleware[i](handler)
+	}
+
+	ctx := newContext(remoteAddr, req, params)
+	return handler(ctx)
+}
+
+// serveConn dispatches a parsed request read off a raw TCP connection and
+// writes the response, including the Content-Length or Transfer-Encoding
+// framing headers the client needs to find the end of the response on a
+// kept-alive connection. keepAlive, decided by the caller, sets the
+// Connection header.
+func (r *Router) serveConn(conn net.Conn, req *Request, keepAlive bool) {
+	res := r.dispatch(conn.RemoteAddr().String(), req)
+
+	headersToWrite := res.headers
+	if headersToWrite == nil {
+		headersToWrite = httpHeaders{}
+	}
+	headersToWrite["Content-Type"] = res.contentType
+	switch {
+	case res.bodyReader != nil && res.contentLength != nil:
+		headersToWrite["Content-Length"] = fmt.Sprintf("%d", *res.contentLength)
+	case res.bodyReader != nil:
+		headersToWrite["Transfer-Encoding"] = "chunked"
+	default:
+		headersToWrite["Content-Length"] = fmt.Sprintf("%d", len(res.body))
+	}
+	writeResponse(conn, res, headersToWrite, keepAlive)
+}
+
+func notFoundHandler(_ *Context) Response {
+	return Response{statusCode: 404, reason: "Not Found"}
+}
@@ -1,137 +1,265 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
-	"compress/gzip"
 	"fmt"
+	"io"
 	"net"
 	"os"
-	"slices"
+	"strconv"
 	"strings"
+	"time"
 )
 
-type Request struct {
-	path    string
-	headers map[string]string
-	body    string
-}
+const idleTimeout = 120 * time.Second
 
-type Response struct {
-	statusCode  int
-	reason      string
-	contentType string
+type Request struct {
+	method      string
+	path        string
+	rawQuery    string
+	httpVersion string
+	headers     map[string]string
 	body        string
 }
 
-// Router to map paths to handler functions
-type Router struct {
-	routes map[string]func(*Request) Response
+// Response describes an HTTP response. A handler sets either body, for
+// content small enough to hold in memory, or bodyReader, to have the body
+// streamed to the client. If bodyReader also implements io.Closer, it is
+// closed once fully written. contentLength, when set, is the exact number
+// of bytes bodyReader will yield, sent as Content-Length instead of
+// Transfer-Encoding: chunked (e.g. for a Range response); nil means the
+// length isn't known upfront, so the body is chunked. headers carries any
+// extra response headers a handler or middleware wants to set, e.g.
+// WWW-Authenticate; Content-Type/-Length/Transfer-Encoding are added by
+// the router.
+type Response struct {
+	statusCode    int
+	reason        string
+	contentType   string
+	body          string
+	bodyReader    io.Reader
+	contentLength *int64
+	headers       httpHeaders
 }
 
-func NewRouter() *Router {
-	return &Router{routes: make(map[string]func(*Request) Response)}
-}
+func main() {
+	router := NewRouter()
+	router.Use(Logger(), Recovery(), Compression())
+	router.HandleFunc("GET", "/", mainPageHandler)
+	router.HandleFunc("GET", "/echo/:msg", echoHandler)
+	router.HandleFunc("GET", "/user-agent", userAgentHandler)
+	router.HandleFunc("GET", "/files/archive", archiveGetHandler)
+	router.HandleFunc("GET", "/files/:name", filesGetHandler)
+	if user, ok := argValue("-upload-user"); ok {
+		pass, _ := argValue("-upload-pass")
+		// Per-route middleware: only uploads require a login, unlike
+		// Logger/Recovery/Compression above which apply to every request.
+		router.HandleFunc("POST", "/files/:name", filesPostHandler, BasicAuth(user, pass))
+	} else {
+		router.HandleFunc("POST", "/files/:name", filesPostHandler)
+	}
 
-func (r *Router) HandleFunc(method string, path string, handler func(*Request) Response) {
-	r.routes[method+path] = handler
-}
+	app := NewApp(router)
 
-func (r *Router) ServeHTTP(conn net.Conn, request string) {
-	parts := strings.Split(request, "\r\n")
-	if len(parts) < 1 {
-		return
-	}
-	lineFields := strings.Fields(parts[0])
-	if len(lineFields) < 2 {
+	if hasArg("-fcgi") {
+		if sock, ok := argValue("-fcgi-sock"); ok {
+			if err := app.RunFCGI("unix", sock); err != nil {
+				fmt.Println("FastCGI (unix) error:", err)
+				os.Exit(1)
+			}
+			return
+		}
+		addr, ok := argValue("-fcgi-addr")
+		if !ok {
+			addr = ":9000"
+		}
+		if err := app.RunFCGI("tcp", addr); err != nil {
+			fmt.Println("FastCGI error:", err)
+			os.Exit(1)
+		}
 		return
 	}
 
-	method := lineFields[0]
-	path := lineFields[1]
-	headers := parseHeaders(parts[1:])
-	body := parts[len(parts)-1]
+	if err := app.Run("0.0.0.0:4221"); err != nil {
+		fmt.Println("Failed to bind to port 4221")
+		os.Exit(1)
+	}
+}
 
-	pathParts := strings.Split(path, "/")
-	if len(pathParts) < 2 {
-		return
+// argValue returns the value following a flag like "-fcgi-addr" in
+// os.Args, e.g. argValue("-fcgi-addr") for "... -fcgi-addr :9000 ...".
+func argValue(flag string) (string, bool) {
+	for i, a := range os.Args {
+		if a == flag && i+1 < len(os.Args) {
+			return os.Args[i+1], true
+		}
 	}
+	return "", false
+}
 
-	handler, exists := r.routes[method+"/"+pathParts[1]]
-	if !exists {
-		writeResponse(conn, 404, "Not Found", "", httpHeaders{})
-		return
+func hasArg(flag string) bool {
+	for _, a := range os.Args {
+		if a == flag {
+			return true
+		}
 	}
+	return false
+}
 
-	req := Request{path, headers, body}
+// handleConnection services a single TCP connection, reading and responding
+// to as many sequential HTTP requests as the client sends, per HTTP/1.1
+// keep-alive semantics. The connection is closed when the client asks for
+// it (Connection: close, or HTTP/1.0 without Connection: keep-alive), on a
+// framing error, or after idleTimeout of inactivity.
+func handleConnection(conn net.Conn, router *Router) {
+	defer conn.Close()
 
-	res := handler(&req)
-	headersToWrite := httpHeaders{
-		"Content-Type":   res.contentType,
-		"Content-Length": fmt.Sprintf("%d", len(res.body)),
+	br := bufio.NewReader(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(idleTimeout))
+
+		req, err := readRequest(br)
+		if err != nil {
+			if err != io.EOF {
+				fmt.Println("Error reading request:", err)
+			}
+			return
+		}
+
+		keepAlive := shouldKeepAlive(req)
+		conn.SetWriteDeadline(time.Now().Add(idleTimeout))
+		router.serveConn(conn, req, keepAlive)
+
+		if !keepAlive {
+			return
+		}
 	}
-	handleCompression(&req, &res, &headersToWrite)
-	writeResponse(conn, res.statusCode, res.reason, res.body, headersToWrite)
 }
 
-func handleCompression(rq *Request, rs *Response, h *httpHeaders) (bool, string) {
-	encoding, exists := rq.headers["accept-encoding"]
-	if !exists {
-		return false, ""
+// readRequest parses a single HTTP request off br: the request line,
+// headers, and a body framed by either Content-Length or
+// Transfer-Encoding: chunked.
+func readRequest(br *bufio.Reader) (*Request, error) {
+	line, err := readLine(br)
+	if err != nil {
+		return nil, err
 	}
-	encodings := strings.Fields(strings.Replace(encoding, ",", "", -1))
-	gzipExists := slices.Contains(encodings, "gzip")
-	if !gzipExists {
-		return false, ""
+	lineFields := strings.Fields(line)
+	if len(lineFields) < 3 {
+		return nil, fmt.Errorf("malformed request line: %q", line)
 	}
 
-	var b bytes.Buffer
-	gz := gzip.NewWriter(&b)
-	gz.Write([]byte(rs.body))
-	gz.Close()
-	rs.body = b.String()
+	method := lineFields[0]
+	target := lineFields[1]
+	httpVersion := lineFields[2]
 
-	(*h)["Content-Length"] = fmt.Sprintf("%d", len(b.Bytes()))
-	(*h)["Content-Encoding"] = "gzip"
-	return true, ""
-}
+	path, rawQuery := target, ""
+	if i := strings.IndexByte(target, '?'); i != -1 {
+		path, rawQuery = target[:i], target[i+1:]
+	}
 
-func main() {
-	router := NewRouter()
-	router.HandleFunc("GET", "/", mainPageHandler)
-	router.HandleFunc("GET", "/echo", echoHandler)
-	router.HandleFunc("GET", "/user-agent", userAgentHandler)
-	router.HandleFunc("GET", "/files", filesGetHandler)
-	router.HandleFunc("POST", "/files", filesPostHandler)
+	headers, err := readHeaders(br)
+	if err != nil {
+		return nil, err
+	}
 
-	l, err := net.Listen("tcp", "0.0.0.0:4221")
+	body, err := readBody(br, headers)
 	if err != nil {
-		fmt.Println("Failed to bind to port 4221")
-		os.Exit(1)
+		return nil, err
 	}
-	defer l.Close()
 
+	return &Request{method, path, rawQuery, httpVersion, headers, body}, nil
+}
+
+func readLine(br *bufio.Reader) (string, error) {
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func readHeaders(br *bufio.Reader) (map[string]string, error) {
+	headers := make(map[string]string)
 	for {
-		conn, err := l.Accept()
+		line, err := readLine(br)
 		if err != nil {
-			fmt.Println("Error accepting connection: ", err.Error())
-			continue
+			return nil, err
+		}
+		if line == "" {
+			return headers, nil
+		}
+		parts := strings.SplitN(line, ": ", 2)
+		if len(parts) == 2 {
+			// Header field names are case-insensitive, values are not
+			// (e.g. Authorization, ETag), so only fold the name.
+			headers[strings.ToLower(parts[0])] = parts[1]
 		}
-		go handleConnection(conn, router)
 	}
 }
 
-func handleConnection(conn net.Conn, router *Router) {
-	defer conn.Close()
+func readBody(br *bufio.Reader, headers map[string]string) (string, error) {
+	if strings.Contains(headers["transfer-encoding"], "chunked") {
+		return readChunkedBody(br)
+	}
 
-	buf := make([]byte, 1024)
-	n, err := conn.Read(buf)
-	if err != nil {
-		fmt.Println("Error reading from connection:", err)
-		return
+	lengthHeader, exists := headers["content-length"]
+	if !exists {
+		return "", nil
+	}
+	length, err := strconv.Atoi(lengthHeader)
+	if err != nil || length < 0 {
+		return "", fmt.Errorf("invalid Content-Length: %q", lengthHeader)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(br, body); err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+func readChunkedBody(br *bufio.Reader) (string, error) {
+	var body bytes.Buffer
+	for {
+		sizeLine, err := readLine(br)
+		if err != nil {
+			return "", err
+		}
+		sizeLine = strings.SplitN(sizeLine, ";", 2)[0]
+		size, err := strconv.ParseInt(sizeLine, 16, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid chunk size: %q", sizeLine)
+		}
+		if size == 0 {
+			// Trailing headers (if any) followed by the final CRLF.
+			if _, err := readHeaders(br); err != nil {
+				return "", err
+			}
+			return body.String(), nil
+		}
+
+		if _, err := io.CopyN(&body, br, size); err != nil {
+			return "", err
+		}
+		if _, err := readLine(br); err != nil { // trailing CRLF after chunk data
+			return "", err
+		}
 	}
+}
 
-	request := string(buf[:n])
-	router.ServeHTTP(conn, request)
+// shouldKeepAlive reports whether the connection should stay open after
+// this request according to the Connection header and HTTP version.
+func shouldKeepAlive(req *Request) bool {
+	connection := req.headers["connection"]
+	if connection == "close" {
+		return false
+	}
+	if req.httpVersion == "HTTP/1.0" {
+		return connection == "keep-alive"
+	}
+	return true
 }
 
 type httpHeaders map[string]string
@@ -144,81 +272,111 @@ func (h *httpHeaders) String() string {
 	return sb.String()
 }
 
-func writeResponse(conn net.Conn, statusCode int, statusReason, body string, headers httpHeaders) {
+func writeResponse(conn net.Conn, res Response, headers httpHeaders, keepAlive bool) {
+	if keepAlive {
+		headers["Connection"] = "keep-alive"
+	} else {
+		headers["Connection"] = "close"
+	}
+
 	sb := strings.Builder{}
-	sb.WriteString(fmt.Sprintf("HTTP/1.1 %d %s\r\n", statusCode, statusReason))
+	sb.WriteString(fmt.Sprintf("HTTP/1.1 %d %s\r\n", res.statusCode, res.reason))
 	sb.WriteString(headers.String())
 	sb.WriteString("\r\n")
-	sb.WriteString(body)
-	sb.WriteString("\r\n")
 	conn.Write([]byte(sb.String()))
-}
 
-func parseHeaders(headerLines []string) map[string]string {
-	headers := make(map[string]string)
-	for _, line := range headerLines {
-		if line == "" {
-			continue
+	if res.bodyReader != nil {
+		var err error
+		if res.contentLength != nil {
+			err = writeFixedLengthBody(conn, res.bodyReader)
+		} else {
+			err = writeStreamingBody(conn, res.bodyReader, encodingFactories[headers["Content-Encoding"]])
 		}
-		parts := strings.SplitN(line, ": ", 2)
-		if len(parts) == 2 {
-			headers[strings.ToLower(parts[0])] = strings.ToLower(parts[1])
+		if err != nil {
+			fmt.Println("Error writing response body:", err)
 		}
+		return
 	}
-	return headers
+	conn.Write([]byte(res.body))
 }
 
-func echoHandler(r *Request) Response {
-	pathParts := strings.Split(r.path, "/")
-	if len(pathParts) == 3 {
-		return Response{200, "OK", "text/plain", pathParts[2]}
+// writeFixedLengthBody copies body to conn verbatim, for a response whose
+// exact length is already known (and thus sent as Content-Length rather
+// than chunked), such as a single-range file read. If body also implements
+// io.Closer, it is closed afterwards.
+func writeFixedLengthBody(conn net.Conn, body io.Reader) error {
+	if closer, ok := body.(io.Closer); ok {
+		defer closer.Close()
 	}
-	return Response{404, "Not Found", "text/plain", "Not Found"}
+	_, err := io.Copy(&deadlineWriter{conn}, body)
+	return err
 }
 
-func filesGetHandler(r *Request) Response {
-	pathParts := strings.Split(r.path, "/")
-	if len(pathParts) == 3 {
-		var dirPath string
-		if len(os.Args) < 3 {
-			dirPath = ""
-		} else {
-			dirPath = os.Args[2]
-		}
-		fileName := pathParts[2]
-		data, err := os.ReadFile(dirPath + fileName)
-		if err != nil {
-			return Response{404, "Not Found", "text/plain", err.Error()}
-		}
-		return Response{200, "OK", "application/octet-stream", string(data)}
-	}
-	return Response{404, "Not Found", "text/plain", "Not Found"}
+// deadlineWriter refreshes conn's write deadline before every Write, so a
+// large streamed or fixed-length body isn't bounded by the single
+// idleTimeout deadline set for the request, only by how long it goes
+// without making write progress.
+type deadlineWriter struct {
+	conn net.Conn
 }
 
-func filesPostHandler(r *Request) Response {
-	pathParts := strings.Split(r.path, "/")
-	if len(pathParts) == 3 {
-		var dirPath string
-		if len(os.Args) < 3 {
-			dirPath = ""
-		} else {
-			dirPath = os.Args[2]
-		}
-		fileName := pathParts[2]
-		os.WriteFile(dirPath+fileName, []byte(r.body), 0644)
-		return Response{201, "Created", "text/plain", "saved"}
-	}
-	return Response{404, "Not Found", "text/plain", "Not Found"}
+func (d *deadlineWriter) Write(p []byte) (int, error) {
+	d.conn.SetWriteDeadline(time.Now().Add(idleTimeout))
+	return d.conn.Write(p)
 }
 
-func userAgentHandler(r *Request) Response {
-	userAgent, exists := r.headers["user-agent"]
-	if !exists {
-		return Response{400, "Not Found", "text/plain", "User-Agent header not found"}
+// chunkedWriter encodes each Write as one HTTP chunk. Close writes the
+// terminating zero-length chunk.
+type chunkedWriter struct {
+	w io.Writer
+}
+
+func (c *chunkedWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if _, err := fmt.Fprintf(c.w, "%x\r\n", len(p)); err != nil {
+		return 0, err
 	}
-	return Response{200, "OK", "text/plain", userAgent}
+	if _, err := c.w.Write(p); err != nil {
+		return 0, err
+	}
+	if _, err := c.w.Write([]byte("\r\n")); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *chunkedWriter) Close() error {
+	_, err := c.w.Write([]byte("0\r\n\r\n"))
+	return err
 }
 
-func mainPageHandler(_ *Request) Response {
-	return Response{200, "OK", "text/html", "<h1>Hello World</h1>"}
+// writeStreamingBody copies body to conn as chunked transfer encoding,
+// optionally running it through encode on the fly, without ever holding
+// the whole body in memory. encode may be nil for an uncompressed
+// (identity) response. If body also implements io.Closer, it is closed
+// afterwards.
+func writeStreamingBody(conn net.Conn, body io.Reader, encode EncoderFactory) error {
+	if closer, ok := body.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	cw := &chunkedWriter{&deadlineWriter{conn}}
+	var dst io.Writer = cw
+	var encoder io.WriteCloser
+	if encode != nil {
+		encoder = encode(cw)
+		dst = encoder
+	}
+
+	if _, err := io.Copy(dst, body); err != nil {
+		return err
+	}
+	if encoder != nil {
+		if err := encoder.Close(); err != nil {
+			return err
+		}
+	}
+	return cw.Close()
 }